@@ -0,0 +1,47 @@
+// Command mal-msgpack-eval reads a stream of MessagePack-encoded mal
+// forms from stdin and writes one MessagePack-encoded value per form to
+// stdout.
+//
+// This tree only carries the types package (the reader, env and eval
+// pipeline live in separate packages not present here), so the forms
+// are not evaluated: each decoded form is re-encoded unchanged, which
+// exercises the codec end-to-end over a real stdin/stdout round trip.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"types"
+)
+
+func main() {
+	in := types.NewMsgPackDecoder(os.Stdin)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	for {
+		form, err := in.Decode()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mal-msgpack-eval: decode: %v\n", err)
+			os.Exit(1)
+		}
+
+		result := form // echoed unchanged; no evaluation happens here
+
+		b, err := types.MarshalMsgPack(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mal-msgpack-eval: encode: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := out.Write(b); err != nil {
+			fmt.Fprintf(os.Stderr, "mal-msgpack-eval: write: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package types
+
+import "testing"
+
+// TestEqualQHandlesSelfReferentialAtom builds the canonical cycle from
+// the request - (def! a (atom nil)) (reset! a a) - and confirms Equal_Q
+// terminates instead of recursing forever.
+func TestEqualQHandlesSelfReferentialAtom(t *testing.T) {
+	a := &Atom{}
+	a.Set(a)
+	if !Equal_Q(a, a) {
+		t.Fatalf("Equal_Q(a, a) = false for a self-referential atom, want true")
+	}
+}
+
+// TestEqualQHandlesAtomCycleThroughList covers a cycle that runs
+// through an intermediate list rather than an atom referencing itself
+// directly.
+func TestEqualQHandlesAtomCycleThroughList(t *testing.T) {
+	a := &Atom{}
+	b := &Atom{}
+	a.Set(List{Val: ListMalType{b}})
+	b.Set(List{Val: ListMalType{a}})
+
+	if !Equal_Q(a, a) {
+		t.Fatalf("Equal_Q(a, a) = false for mutually-referential atoms, want true")
+	}
+}
+
+// TestPrStrHandlesSelfReferentialAtom confirms the printer detects the
+// same cycle and prints a #<cycle> marker instead of recursing.
+func TestPrStrHandlesSelfReferentialAtom(t *testing.T) {
+	a := &Atom{}
+	a.Set(a)
+
+	want := "(atom #<cycle>)"
+	if got := Pr_str(a, true); got != want {
+		t.Fatalf("Pr_str(a) = %q, want %q", got, want)
+	}
+}
+
+// TestPrStrHandlesAtomCycleThroughVector mirrors the list case above
+// for a cycle running through a vector.
+func TestPrStrHandlesAtomCycleThroughVector(t *testing.T) {
+	a := &Atom{}
+	a.Set(Vector{Val: []MalType{a}})
+
+	want := "(atom [#<cycle>])"
+	if got := Pr_str(a, true); got != want {
+		t.Fatalf("Pr_str(a) = %q, want %q", got, want)
+	}
+}
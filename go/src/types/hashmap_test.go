@@ -0,0 +1,87 @@
+package types
+
+import "testing"
+
+// TestPrStrHashMapOrderStable confirms pr-str walks a hash-map's
+// entries in insertion order, so output is stable across runs rather
+// than scrambled by Go's randomized map iteration.
+func TestPrStrHashMapOrderStable(t *testing.T) {
+	m := NewMalHashMap()
+	ka, _ := NewKeyword("a")
+	kb, _ := NewKeyword("b")
+	kc, _ := NewKeyword("c")
+	m.Set(ka.(string), 1)
+	m.Set(kb.(string), 2)
+	m.Set(kc.(string), 3)
+	hm := HashMap{Val: m}
+
+	want := "{:a 1 :b 2 :c 3}"
+	for i := 0; i < 5; i++ {
+		if got := Pr_str(hm, true); got != want {
+			t.Fatalf("run %d: Pr_str = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestMalHashMapPreservesOrderAcrossSetDelete covers the assoc/dissoc
+// ordering guarantee the request asked for: Set appends new keys at
+// the end and leaves existing keys' positions alone, Delete closes the
+// gap it leaves without disturbing the relative order of the rest.
+func TestMalHashMapPreservesOrderAcrossSetDelete(t *testing.T) {
+	m := NewMalHashMap()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	m.Set("d", 4)
+
+	m.Delete("b")
+	if got, want := m.Keys(), []string{"a", "c", "d"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("after Delete: Keys() = %v, want %v", got, want)
+	}
+
+	m.Set("e", 5)
+	if got, want := m.Keys(), []string{"a", "c", "d", "e"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("after Set of new key: Keys() = %v, want %v", got, want)
+	}
+
+	m.Set("a", 100)
+	if got, want := m.Keys(), []string{"a", "c", "d", "e"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("after Set of existing key: Keys() = %v, want %v", got, want)
+	}
+	if v, ok := m.Get("a"); !ok || v != 100 {
+		t.Fatalf("Get(a) = %v, %v, want 100, true", v, ok)
+	}
+}
+
+// TestZeroValueHashMapIsSafe confirms a HashMap{} with a nil Val -
+// e.g. what a zero-value struct literal or a partially-initialized
+// decode produces - behaves like an empty map instead of panicking,
+// the same way the old map[string]MalType representation did for
+// free.
+func TestZeroValueHashMapIsSafe(t *testing.T) {
+	var zero HashMap
+	if !Equal_Q(zero, HashMap{}) {
+		t.Fatalf("Equal_Q(zero, HashMap{}) = false, want true")
+	}
+	if got, want := Pr_str(zero, true), "{}"; got != want {
+		t.Fatalf("Pr_str(zero) = %q, want %q", got, want)
+	}
+	if _, err := Marshal(zero); err != nil {
+		t.Fatalf("Marshal(zero): %v", err)
+	}
+	if _, err := MarshalMsgPack(zero); err != nil {
+		t.Fatalf("MarshalMsgPack(zero): %v", err)
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,499 @@
+package types
+
+// JSON interchange format for MalType.
+//
+// The wire format tags each compound value with its kind so that decoding
+// is unambiguous: {"symbol":...}, {"keyword":...}, {"atom":...},
+// {"list":[...]}, {"vector":[...]}, {"hashmap":{...}}; the atom, list,
+// vector and hashmap forms optionally carry a "meta" field. Scalars round-trip as plain JSON null, bool,
+// string and number (json.Number, to preserve integer vs float), except
+// that keywords - which are represented internally as strings prefixed
+// with the "\u029e" sentinel - are written as {"keyword":"name"} so they
+// can't be confused with ordinary strings on the wire. Func and MalFunc
+// hold live closures and are not serializable.
+//
+// Decoding is token-driven: Decoder wraps a json.Decoder and constructs
+// each MalType directly from the token stream, without ever staging a
+// []json.RawMessage of the input. This lets Decode() be called
+// repeatedly to stream a sequence of top-level values out of a Reader
+// (a socket, a growing file, ...) without buffering the whole payload,
+// and keeps memory proportional to the single value being built rather
+// than to the size of already-decoded siblings.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Marshal encodes a MalType as JSON.
+func Marshal(obj MalType) ([]byte, error) {
+	raw, err := marshalMalType(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(raw), nil
+}
+
+// Unmarshal decodes a single JSON-encoded MalType.
+func Unmarshal(b []byte) (MalType, error) {
+	return NewDecoder(bytes.NewReader(b)).Decode()
+}
+
+func JSONUnmarshal(buffer []byte, ast interface{}) error {
+	reader := bytes.NewReader(buffer)
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(ast)
+}
+
+// marshalMalType encodes a single MalType value as a raw JSON token,
+// dispatching to the MarshalJSON methods below for compound types.
+func marshalMalType(obj MalType) (json.RawMessage, error) {
+	if obj == nil {
+		return json.RawMessage("null"), nil
+	}
+	switch v := obj.(type) {
+	case bool:
+		return json.Marshal(v)
+	case int:
+		return json.Marshal(v)
+	case json.Number:
+		return json.RawMessage(v), nil
+	case string:
+		if Keyword_Q(v) {
+			return marshalKeyword(strings.TrimPrefix(v, "\u029e"))
+		}
+		return json.Marshal(v)
+	case Symbol, List, Vector, HashMap, *Atom:
+		return json.Marshal(v)
+	case Func, MalFunc:
+		return nil, fmt.Errorf("cannot marshal %T to JSON: functions are not serializable", obj)
+	default:
+		return nil, fmt.Errorf("cannot marshal %T to JSON", obj)
+	}
+}
+
+func marshalKeyword(name string) (json.RawMessage, error) {
+	nameJSON, err := json.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(`{"keyword":` + string(nameJSON) + `}`), nil
+}
+
+// marshalTagged writes {"<tag>":<value>[,"meta":<meta>]}.
+func marshalTagged(tag string, value json.RawMessage, meta MalType) ([]byte, error) {
+	tagJSON, err := json.Marshal(tag)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	buf.Write(tagJSON)
+	buf.WriteByte(':')
+	buf.Write(value)
+	if meta != nil {
+		metaJSON, err := marshalMalType(meta)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"meta":`)
+		buf.Write(metaJSON)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (l List) MarshalJSON() ([]byte, error) {
+	items := make([]json.RawMessage, len(l.Val))
+	for i, it := range l.Val {
+		raw, err := marshalMalType(it)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = raw
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	return marshalTagged("list", itemsJSON, l.Meta)
+}
+
+func (v Vector) MarshalJSON() ([]byte, error) {
+	items := make([]json.RawMessage, len(v.Val))
+	for i, it := range v.Val {
+		raw, err := marshalMalType(it)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = raw
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	return marshalTagged("vector", itemsJSON, v.Meta)
+}
+
+func (h HashMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	var rangeErr error
+	h.Val.Range(func(k string, v MalType) bool {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		keyJSON, err := json.Marshal(k)
+		if err != nil {
+			rangeErr = err
+			return false
+		}
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		raw, err := marshalMalType(v)
+		if err != nil {
+			rangeErr = err
+			return false
+		}
+		buf.Write(raw)
+		return true
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+	buf.WriteByte('}')
+	return marshalTagged("hashmap", buf.Bytes(), h.Meta)
+}
+
+func (a *Atom) MarshalJSON() ([]byte, error) {
+	valJSON, err := marshalMalType(a.Val)
+	if err != nil {
+		return nil, err
+	}
+	return marshalTagged("atom", valJSON, a.Meta)
+}
+
+// DefaultMaxDepth is the nesting depth a Decoder enforces unless the
+// caller overrides it with SetMaxDepth.
+const DefaultMaxDepth = 1000
+
+// MaxDepthError is returned by Decode when an input nests lists,
+// vectors or hashmaps deeper than the decoder's MaxDepth, rather than
+// let the recursive descent blow the Go stack.
+type MaxDepthError struct {
+	MaxDepth int
+}
+
+func (e *MaxDepthError) Error() string {
+	return fmt.Sprintf("json-decode: exceeded max nesting depth of %d", e.MaxDepth)
+}
+
+// Decoder decodes a stream of tagged-JSON-encoded MalType values read
+// one token at a time from an io.Reader, so a sequence of top-level
+// values can be consumed without buffering the whole input.
+type Decoder struct {
+	dec      *json.Decoder
+	maxDepth int
+}
+
+// NewDecoder returns a Decoder reading from r, with MaxDepth set to
+// DefaultMaxDepth. Call Decode repeatedly to pull successive top-level
+// mal values out of the stream.
+func NewDecoder(r io.Reader) *Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Decoder{dec: dec, maxDepth: DefaultMaxDepth}
+}
+
+// SetMaxDepth overrides the nesting depth at which Decode gives up on
+// hostile input and returns a *MaxDepthError instead of recursing
+// further. The underlying encoding/json decoder also has its own fixed
+// limit of 10000 JSON nesting levels (5000 nested mal lists or
+// vectors), past which Decode fails with encoding/json's own error
+// whatever n is.
+func (d *Decoder) SetMaxDepth(n int) {
+	d.maxDepth = n
+}
+
+// Decode reads and returns the next top-level MalType, or io.EOF once
+// the stream is exhausted.
+func (d *Decoder) Decode() (MalType, error) {
+	return decodeTokenValue(d.dec, 0, d.maxDepth)
+}
+
+// decodeTokenValue consumes exactly the tokens of one JSON value and
+// constructs the corresponding MalType, recursing into decodeObject or
+// decodeArray for compound values. depth is the nesting level of this
+// value; decodeObject/decodeArray reject depths beyond maxDepth rather
+// than recurse further.
+func decodeTokenValue(dec *json.Decoder, depth, maxDepth int) (MalType, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			return decodeObject(dec, depth+1, maxDepth)
+		case '[':
+			return decodeArray(dec, depth+1, maxDepth)
+		}
+		return nil, fmt.Errorf("json-decode: unexpected delimiter %q", t)
+	case bool, json.Number, string:
+		return t, nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("json-decode: unexpected token %v", tok)
+	}
+}
+
+// decodeArray consumes tokens up to the matching ']' (the leading '['
+// has already been consumed by the caller) and returns the decoded
+// elements.
+func decodeArray(dec *json.Decoder, depth, maxDepth int) ([]MalType, error) {
+	if depth > maxDepth {
+		return nil, &MaxDepthError{MaxDepth: maxDepth}
+	}
+	items := []MalType{}
+	for dec.More() {
+		v, err := decodeTokenValue(dec, depth, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	if _, err := dec.Token(); err != nil { // consume ']'
+		return nil, err
+	}
+	return items, nil
+}
+
+// decodeObject consumes tokens up to the matching '}' (the leading '{'
+// has already been consumed by the caller), recognizes exactly one of
+// the mal tag keys, and builds the corresponding MalType.
+func decodeObject(dec *json.Decoder, depth, maxDepth int) (MalType, error) {
+	if depth > maxDepth {
+		return nil, &MaxDepthError{MaxDepth: maxDepth}
+	}
+	var tag string
+	var haveTag bool
+	var symbolOrKeyword string
+	var atomVal MalType
+	var items []MalType
+	var hashmap *MalHashMap
+	var meta MalType
+	var haveMeta bool
+
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyTok.(string)
+		if !ok {
+			return nil, fmt.Errorf("json-decode: expected object key, got %v", keyTok)
+		}
+		if haveTag && key != "meta" {
+			return nil, fmt.Errorf("json-decode: unexpected second tag key %q", key)
+		}
+		switch key {
+		case "meta":
+			v, err := decodeTokenValue(dec, depth, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			meta, haveMeta = v, true
+		case "symbol", "keyword":
+			v, err := decodeTokenValue(dec, depth, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("json-decode of %s failed: expected string", key)
+			}
+			tag, symbolOrKeyword, haveTag = key, s, true
+		case "atom":
+			v, err := decodeTokenValue(dec, depth, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			tag, atomVal, haveTag = key, v, true
+		case "list", "vector":
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return nil, fmt.Errorf("json-decode of %s failed: expected array", key)
+			}
+			arr, err := decodeArray(dec, depth, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			tag, items, haveTag = key, arr, true
+		case "hashmap":
+			m, err := decodeHashMap(dec, depth, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			tag, hashmap, haveTag = key, m, true
+		case "fn":
+			return nil, errors.New("json-decode of fn not supported: functions are not serializable")
+		default:
+			return nil, fmt.Errorf("json-decode of unknown type: unexpected key %q", key)
+		}
+	}
+	if _, err := dec.Token(); err != nil { // consume '}'
+		return nil, err
+	}
+	if !haveTag {
+		return nil, errors.New("json-decode of unknown type")
+	}
+
+	switch tag {
+	case "symbol":
+		return Symbol{symbolOrKeyword}, nil
+	case "keyword":
+		return NewKeyword(symbolOrKeyword)
+	case "atom":
+		a := &Atom{Val: atomVal}
+		if haveMeta {
+			a.Meta = meta
+		}
+		return a, nil
+	case "list":
+		l := List{Val: ListMalType(items)}
+		if haveMeta {
+			l.Meta = meta
+		}
+		return l, nil
+	case "vector":
+		v := Vector{Val: items}
+		if haveMeta {
+			v.Meta = meta
+		}
+		return v, nil
+	case "hashmap":
+		h := HashMap{Val: hashmap}
+		if haveMeta {
+			h.Meta = meta
+		}
+		return h, nil
+	default:
+		return nil, errors.New("json-decode of unknown type")
+	}
+}
+
+// decodeHashMap consumes a plain (untagged) JSON object of string keys
+// to mal values, as used for the body of a {"hashmap":...} value. Keys
+// are inserted into the result in the order they appear on the wire, so
+// a round trip through Marshal/Unmarshal preserves insertion order.
+func decodeHashMap(dec *json.Decoder, depth, maxDepth int) (*MalHashMap, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("json-decode of hashmap failed: expected object")
+	}
+	if depth > maxDepth {
+		return nil, &MaxDepthError{MaxDepth: maxDepth}
+	}
+	m := NewMalHashMap()
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := keyTok.(string)
+		if !ok {
+			return nil, fmt.Errorf("json-decode of hashmap failed: expected string key, got %v", keyTok)
+		}
+		v, err := decodeTokenValue(dec, depth, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		m.Set(k, v)
+	}
+	if _, err := dec.Token(); err != nil { // consume '}'
+		return nil, err
+	}
+	return m, nil
+}
+
+// UnmarshalJSON decodes a bare JSON array of tagged mal values.
+func (j *ListMalType) UnmarshalJSON(b []byte) error {
+	v, err := Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	arr, ok := v.([]MalType)
+	if !ok {
+		return fmt.Errorf("json-decode of list failed: expected array, got %T", v)
+	}
+	*j = ListMalType(arr)
+	return nil
+}
+
+func (l *List) UnmarshalJSON(b []byte) error {
+	v, err := Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	lv, ok := v.(List)
+	if !ok {
+		return fmt.Errorf("json-decode of list failed: expected list, got %T", v)
+	}
+	*l = lv
+	return nil
+}
+
+func (v *Vector) UnmarshalJSON(b []byte) error {
+	val, err := Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	vv, ok := val.(Vector)
+	if !ok {
+		return fmt.Errorf("json-decode of vector failed: expected vector, got %T", val)
+	}
+	*v = vv
+	return nil
+}
+
+func (h *HashMap) UnmarshalJSON(b []byte) error {
+	v, err := Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	hv, ok := v.(HashMap)
+	if !ok {
+		return fmt.Errorf("json-decode of hashmap failed: expected hashmap, got %T", v)
+	}
+	*h = hv
+	return nil
+}
+
+func (a *Atom) UnmarshalJSON(b []byte) error {
+	v, err := Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	av, ok := v.(*Atom)
+	if !ok {
+		return fmt.Errorf("json-decode of atom failed: expected atom, got %T", v)
+	}
+	*a = *av
+	return nil
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestDecoderDeepNesting exercises the token-driven decoder against a
+// 100k-deep nested list, the scale chunk0-2 asked for to demonstrate
+// that decoding no longer stages an intermediate []json.RawMessage per
+// level - the decoder just walks the token stream, so memory stays
+// proportional to the single value being built rather than the whole
+// input.
+func TestDecoderDeepNesting(t *testing.T) {
+	const depth = 100000
+	var buf strings.Builder
+	for i := 0; i < depth; i++ {
+		buf.WriteString(`{"list":[`)
+	}
+	buf.WriteString("null")
+	for i := 0; i < depth; i++ {
+		buf.WriteString("]}")
+	}
+
+	dec := NewDecoder(strings.NewReader(buf.String()))
+	dec.SetMaxDepth(depth)
+	v, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode of %d-deep nesting failed: %v", depth, err)
+	}
+
+	got := 0
+	for {
+		l, ok := v.(List)
+		if !ok {
+			break
+		}
+		if len(l.Val) != 1 {
+			t.Fatalf("expected single-element list at level %d, got %d elements", got, len(l.Val))
+		}
+		got++
+		v = l.Val[0]
+	}
+	if got != depth {
+		t.Fatalf("got %d levels of nesting, want %d", got, depth)
+	}
+	if v != nil {
+		t.Fatalf("innermost value = %#v, want nil", v)
+	}
+}
+
+// TestDecoderStreamsSuccessiveValues confirms Decode can be called
+// repeatedly to pull a sequence of top-level values out of one Reader,
+// the streaming use case the Decoder was built for.
+func TestDecoderStreamsSuccessiveValues(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`1 2 "three"`))
+	want := []MalType{json.Number("1"), json.Number("2"), "three"}
+	for i, w := range want {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Decode #%d: %v", i, err)
+		}
+		if got != w {
+			t.Fatalf("Decode #%d = %#v, want %#v", i, got, w)
+		}
+	}
+	if _, err := dec.Decode(); err == nil {
+		t.Fatalf("expected an error once the stream is exhausted")
+	}
+}
+
+// TestDecoderRejectsExcessiveNesting is the hostile-input regression
+// case chunk0-4 asked for: a pathological input nested past the
+// default MaxDepth must come back as a *MaxDepthError, not crash the
+// process.
+func TestDecoderRejectsExcessiveNesting(t *testing.T) {
+	const depth = DefaultMaxDepth + 1
+	var buf strings.Builder
+	for i := 0; i < depth; i++ {
+		buf.WriteString(`{"list":[`)
+	}
+	buf.WriteString("null")
+	for i := 0; i < depth; i++ {
+		buf.WriteString("]}")
+	}
+
+	_, err := NewDecoder(strings.NewReader(buf.String())).Decode()
+	var depthErr *MaxDepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("Decode error = %v, want *MaxDepthError", err)
+	}
+	if depthErr.MaxDepth != DefaultMaxDepth {
+		t.Fatalf("MaxDepth = %d, want %d", depthErr.MaxDepth, DefaultMaxDepth)
+	}
+}
+
+// TestDecoderSetMaxDepth confirms SetMaxDepth actually governs the
+// depth at which Decode gives up, in both directions.
+func TestDecoderSetMaxDepth(t *testing.T) {
+	const depth = 50
+	var buf strings.Builder
+	for i := 0; i < depth; i++ {
+		buf.WriteString(`{"vector":[`)
+	}
+	buf.WriteString("1")
+	for i := 0; i < depth; i++ {
+		buf.WriteString("]}")
+	}
+
+	dec := NewDecoder(strings.NewReader(buf.String()))
+	dec.SetMaxDepth(depth - 1)
+	if _, err := dec.Decode(); err == nil {
+		t.Fatalf("expected error with MaxDepth below actual nesting")
+	}
+
+	dec2 := NewDecoder(strings.NewReader(buf.String()))
+	dec2.SetMaxDepth(depth)
+	if _, err := dec2.Decode(); err != nil {
+		t.Fatalf("unexpected error with sufficient MaxDepth: %v", err)
+	}
+}
@@ -0,0 +1,654 @@
+package types
+
+// MessagePack interchange format for MalType.
+//
+// This mirrors the JSON codec in json.go but uses MessagePack's compact
+// binary encoding and its ext mechanism (rather than tagged JSON
+// objects) to distinguish mal-specific values from generic arrays and
+// maps: ext 1 = Symbol, ext 2 = Keyword, ext 3 = Atom, ext 4 = Vector,
+// ext 5 = HashMap, ext 6 = List - each of Atom, Vector, HashMap and
+// List carrying its metadata alongside its value/elements/data as a
+// 2-element array (a bare array with no ext tag also decodes as a
+// meta-less List, since that's what a List's and Vector's own element
+// arrays are encoded as). Numbers use the msgpack int/float families,
+// nil maps to msgpack nil, booleans to bool, strings to str. As with
+// JSON, Func and MalFunc hold live closures and are not serializable.
+//
+// This RPC format exists because JSON leaves mal numbers ambiguous
+// between ints and floats unless callers thread json.Number around by
+// hand, and its text encoding runs 2-3x larger on the wire - both of
+// which matter when shuttling values between mal interpreters.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpExt8     = 0xc7
+	mpExt16    = 0xc8
+	mpExt32    = 0xc9
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpFixExt1  = 0xd4
+	mpFixExt2  = 0xd5
+	mpFixExt4  = 0xd6
+	mpFixExt8  = 0xd7
+	mpFixExt16 = 0xd8
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+)
+
+const (
+	extSymbol  = 1
+	extKeyword = 2
+	extAtom    = 3
+	extVector  = 4
+	extHashMap = 5
+	extList    = 6
+)
+
+// MarshalMsgPack encodes a MalType as MessagePack.
+func MarshalMsgPack(obj MalType) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshalMsgPackValue(obj, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalMsgPack decodes a single MessagePack-encoded MalType.
+func UnmarshalMsgPack(b []byte) (MalType, error) {
+	return NewMsgPackDecoder(bytes.NewReader(b)).Decode()
+}
+
+// mpByteReader is the minimal reading surface readMsgPackValue needs;
+// both bytes.Reader and bufio.Reader satisfy it.
+type mpByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// DefaultMsgPackMaxDepth is the nesting depth a MsgPackDecoder enforces
+// unless the caller overrides it with SetMaxDepth, mirroring
+// DefaultMaxDepth for the JSON codec.
+const DefaultMsgPackMaxDepth = 1000
+
+// MsgPackMaxDepthError is returned by Decode when an input nests
+// arrays, maps, or ext values (atoms, vectors, hashmaps) deeper than
+// the decoder's MaxDepth, rather than let the recursive descent blow
+// the Go stack.
+type MsgPackMaxDepthError struct {
+	MaxDepth int
+}
+
+func (e *MsgPackMaxDepthError) Error() string {
+	return fmt.Sprintf("msgpack-decode: exceeded max nesting depth of %d", e.MaxDepth)
+}
+
+// MsgPackDecoder decodes a stream of back-to-back MessagePack-encoded
+// MalType values, so callers can read forms one at a time off a socket
+// or file the way Decoder does for JSON.
+type MsgPackDecoder struct {
+	r        mpByteReader
+	maxDepth int
+}
+
+// NewMsgPackDecoder returns a MsgPackDecoder reading from r, with
+// MaxDepth set to DefaultMsgPackMaxDepth.
+func NewMsgPackDecoder(r io.Reader) *MsgPackDecoder {
+	br, ok := r.(mpByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &MsgPackDecoder{r: br, maxDepth: DefaultMsgPackMaxDepth}
+}
+
+// SetMaxDepth overrides the nesting depth at which Decode gives up on
+// hostile input and returns a *MsgPackMaxDepthError instead of
+// recursing further.
+func (d *MsgPackDecoder) SetMaxDepth(n int) {
+	d.maxDepth = n
+}
+
+// Decode reads and returns the next MalType, or io.EOF once the stream
+// is exhausted.
+func (d *MsgPackDecoder) Decode() (MalType, error) {
+	return readMsgPackValue(d.r, 0, d.maxDepth)
+}
+
+func marshalMsgPackValue(obj MalType, buf *bytes.Buffer) error {
+	switch v := obj.(type) {
+	case nil:
+		writeMpNil(buf)
+	case bool:
+		writeMpBool(buf, v)
+	case int:
+		return marshalMsgPackNumber(buf, strconv.Itoa(v))
+	case json.Number:
+		return marshalMsgPackNumber(buf, string(v))
+	case string:
+		if Keyword_Q(v) {
+			writeMpExt(buf, extKeyword, []byte(strings.TrimPrefix(v, "\u029e")))
+		} else {
+			writeMpStr(buf, v)
+		}
+	case Symbol:
+		writeMpExt(buf, extSymbol, []byte(v.Val))
+	case *Atom:
+		var inner bytes.Buffer
+		writeMpArrayHeader(&inner, 2)
+		if err := marshalMsgPackValue(v.Val, &inner); err != nil {
+			return err
+		}
+		if err := marshalMsgPackValue(v.Meta, &inner); err != nil {
+			return err
+		}
+		writeMpExt(buf, extAtom, inner.Bytes())
+	case List:
+		var inner bytes.Buffer
+		writeMpArrayHeader(&inner, 2)
+		writeMpArrayHeader(&inner, len(v.Val))
+		for _, it := range v.Val {
+			if err := marshalMsgPackValue(it, &inner); err != nil {
+				return err
+			}
+		}
+		if err := marshalMsgPackValue(v.Meta, &inner); err != nil {
+			return err
+		}
+		writeMpExt(buf, extList, inner.Bytes())
+	case Vector:
+		var inner bytes.Buffer
+		writeMpArrayHeader(&inner, 2)
+		writeMpArrayHeader(&inner, len(v.Val))
+		for _, it := range v.Val {
+			if err := marshalMsgPackValue(it, &inner); err != nil {
+				return err
+			}
+		}
+		if err := marshalMsgPackValue(v.Meta, &inner); err != nil {
+			return err
+		}
+		writeMpExt(buf, extVector, inner.Bytes())
+	case HashMap:
+		var inner bytes.Buffer
+		writeMpArrayHeader(&inner, 2)
+		writeMpMapHeader(&inner, v.Val.Len())
+		var rangeErr error
+		v.Val.Range(func(k string, val MalType) bool {
+			writeMpStr(&inner, k)
+			if err := marshalMsgPackValue(val, &inner); err != nil {
+				rangeErr = err
+				return false
+			}
+			return true
+		})
+		if rangeErr != nil {
+			return rangeErr
+		}
+		if err := marshalMsgPackValue(v.Meta, &inner); err != nil {
+			return err
+		}
+		writeMpExt(buf, extHashMap, inner.Bytes())
+	case Func, MalFunc:
+		return fmt.Errorf("cannot marshal %T to MsgPack: functions are not serializable", obj)
+	default:
+		return fmt.Errorf("cannot marshal %T to MsgPack", obj)
+	}
+	return nil
+}
+
+func marshalMsgPackNumber(buf *bytes.Buffer, s string) error {
+	if iv, err := strconv.ParseInt(s, 10, 64); err == nil {
+		writeMpInt(buf, iv)
+		return nil
+	}
+	fv, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("cannot marshal number %q to MsgPack: %v", s, err)
+	}
+	writeMpFloat64(buf, fv)
+	return nil
+}
+
+func writeMpNil(buf *bytes.Buffer) {
+	buf.WriteByte(mpNil)
+}
+
+func writeMpBool(buf *bytes.Buffer, b bool) {
+	if b {
+		buf.WriteByte(mpTrue)
+	} else {
+		buf.WriteByte(mpFalse)
+	}
+}
+
+func writeMpInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		buf.WriteByte(mpInt8)
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		buf.WriteByte(mpInt16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		buf.WriteByte(mpInt32)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(mpInt64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		buf.Write(b[:])
+	}
+}
+
+func writeMpFloat64(buf *bytes.Buffer, f float64) {
+	buf.WriteByte(mpFloat64)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(f))
+	buf.Write(b[:])
+}
+
+func writeMpStr(buf *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		buf.WriteByte(byte(0xa0 | n))
+	case n <= 0xff:
+		buf.WriteByte(mpStr8)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(mpStr16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(mpStr32)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+	buf.WriteString(s)
+}
+
+func writeMpArrayHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(byte(0x90 | n))
+	case n <= 0xffff:
+		buf.WriteByte(mpArray16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(mpArray32)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+}
+
+func writeMpMapHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(byte(0x80 | n))
+	case n <= 0xffff:
+		buf.WriteByte(mpMap16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(mpMap32)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+}
+
+func writeMpExt(buf *bytes.Buffer, extType int8, payload []byte) {
+	n := len(payload)
+	switch n {
+	case 1:
+		buf.WriteByte(mpFixExt1)
+	case 2:
+		buf.WriteByte(mpFixExt2)
+	case 4:
+		buf.WriteByte(mpFixExt4)
+	case 8:
+		buf.WriteByte(mpFixExt8)
+	case 16:
+		buf.WriteByte(mpFixExt16)
+	default:
+		switch {
+		case n <= 0xff:
+			buf.WriteByte(mpExt8)
+			buf.WriteByte(byte(n))
+		case n <= 0xffff:
+			buf.WriteByte(mpExt16)
+			var b [2]byte
+			binary.BigEndian.PutUint16(b[:], uint16(n))
+			buf.Write(b[:])
+		default:
+			buf.WriteByte(mpExt32)
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], uint32(n))
+			buf.Write(b[:])
+		}
+	}
+	buf.WriteByte(byte(extType))
+	buf.Write(payload)
+}
+
+// readMsgPackValue reads one MessagePack-encoded value. depth is the
+// nesting level of this value; readMsgPackValue rejects depths beyond
+// maxDepth before reading anything, and readMpArrayBody, readMpMapBody
+// and readMpExtBody read their contents at depth+1, so nesting is
+// bounded the way the JSON Decoder bounds it.
+func readMsgPackValue(r mpByteReader, depth, maxDepth int) (MalType, error) {
+	if depth > maxDepth {
+		return nil, &MsgPackMaxDepthError{MaxDepth: maxDepth}
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b >= 0x80 && b <= 0x8f:
+		return readMpMapBody(r, int(b&0x0f), depth, maxDepth)
+	case b >= 0x90 && b <= 0x9f:
+		return readMpArrayBody(r, int(b&0x0f), depth, maxDepth)
+	case b >= 0xa0 && b <= 0xbf:
+		return readMpStrBody(r, int(b&0x1f))
+	}
+	switch b {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpFloat32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		f := math.Float32frombits(binary.BigEndian.Uint32(buf[:]))
+		return json.Number(strconv.FormatFloat(float64(f), 'g', -1, 32)), nil
+	case mpFloat64:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(buf[:]))
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case mpUint8:
+		v, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(uint64(v), 10)), nil
+	case mpUint16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(uint64(binary.BigEndian.Uint16(buf[:])), 10)), nil
+	case mpUint32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(uint64(binary.BigEndian.Uint32(buf[:])), 10)), nil
+	case mpUint64:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(binary.BigEndian.Uint64(buf[:]), 10)), nil
+	case mpInt8:
+		v, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(int64(int8(v)), 10)), nil
+	case mpInt16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(int64(int16(binary.BigEndian.Uint16(buf[:]))), 10)), nil
+	case mpInt32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(buf[:]))), 10)), nil
+	case mpInt64:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(int64(binary.BigEndian.Uint64(buf[:])), 10)), nil
+	case mpStr8:
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return readMpStrBody(r, int(n))
+	case mpStr16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpStrBody(r, int(binary.BigEndian.Uint16(buf[:])))
+	case mpStr32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpStrBody(r, int(binary.BigEndian.Uint32(buf[:])))
+	case mpArray16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpArrayBody(r, int(binary.BigEndian.Uint16(buf[:])), depth, maxDepth)
+	case mpArray32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpArrayBody(r, int(binary.BigEndian.Uint32(buf[:])), depth, maxDepth)
+	case mpMap16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpMapBody(r, int(binary.BigEndian.Uint16(buf[:])), depth, maxDepth)
+	case mpMap32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpMapBody(r, int(binary.BigEndian.Uint32(buf[:])), depth, maxDepth)
+	case mpFixExt1:
+		return readMpExtBody(r, 1, depth, maxDepth)
+	case mpFixExt2:
+		return readMpExtBody(r, 2, depth, maxDepth)
+	case mpFixExt4:
+		return readMpExtBody(r, 4, depth, maxDepth)
+	case mpFixExt8:
+		return readMpExtBody(r, 8, depth, maxDepth)
+	case mpFixExt16:
+		return readMpExtBody(r, 16, depth, maxDepth)
+	case mpExt8:
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return readMpExtBody(r, int(n), depth, maxDepth)
+	case mpExt16:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpExtBody(r, int(binary.BigEndian.Uint16(buf[:])), depth, maxDepth)
+	case mpExt32:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return readMpExtBody(r, int(binary.BigEndian.Uint32(buf[:])), depth, maxDepth)
+	default:
+		return nil, fmt.Errorf("msgpack-decode: unsupported format byte 0x%02x", b)
+	}
+}
+
+func readMpStrBody(r mpByteReader, n int) (MalType, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
+
+func readMpArrayBody(r mpByteReader, n, depth, maxDepth int) (MalType, error) {
+	items := make([]MalType, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := readMsgPackValue(r, depth+1, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return List{Val: ListMalType(items)}, nil
+}
+
+func readMpMapBody(r mpByteReader, n, depth, maxDepth int) (MalType, error) {
+	m := NewMalHashMap()
+	for i := 0; i < n; i++ {
+		k, err := readMsgPackValue(r, depth+1, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack-decode of hashmap failed: expected string key")
+		}
+		v, err := readMsgPackValue(r, depth+1, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		m.Set(ks, v)
+	}
+	return HashMap{Val: m}, nil
+}
+
+func readMpExtBody(r mpByteReader, n, depth, maxDepth int) (MalType, error) {
+	codeByte, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch int8(codeByte) {
+	case extSymbol:
+		return Symbol{string(payload)}, nil
+	case extKeyword:
+		return NewKeyword(string(payload))
+	case extAtom:
+		v, err := readMsgPackValue(bytes.NewReader(payload), depth+1, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("msgpack-decode of atom failed: %w", err)
+		}
+		l, ok := v.(List)
+		if !ok || len(l.Val) != 2 {
+			return nil, errors.New("msgpack-decode of atom failed: expected [value, meta] pair")
+		}
+		return &Atom{Val: l.Val[0], Meta: l.Val[1]}, nil
+	case extList:
+		v, err := readMsgPackValue(bytes.NewReader(payload), depth+1, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("msgpack-decode of list failed: %w", err)
+		}
+		l, ok := v.(List)
+		if !ok || len(l.Val) != 2 {
+			return nil, errors.New("msgpack-decode of list failed: expected [elements, meta] pair")
+		}
+		elems, ok := l.Val[0].(List)
+		if !ok {
+			return nil, errors.New("msgpack-decode of list failed: expected array elements")
+		}
+		return List{Val: elems.Val, Meta: l.Val[1]}, nil
+	case extVector:
+		v, err := readMsgPackValue(bytes.NewReader(payload), depth+1, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("msgpack-decode of vector failed: %w", err)
+		}
+		l, ok := v.(List)
+		if !ok || len(l.Val) != 2 {
+			return nil, errors.New("msgpack-decode of vector failed: expected [elements, meta] pair")
+		}
+		elems, ok := l.Val[0].(List)
+		if !ok {
+			return nil, errors.New("msgpack-decode of vector failed: expected array elements")
+		}
+		return Vector{Val: elems.Val, Meta: l.Val[1]}, nil
+	case extHashMap:
+		v, err := readMsgPackValue(bytes.NewReader(payload), depth+1, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("msgpack-decode of hashmap failed: %w", err)
+		}
+		l, ok := v.(List)
+		if !ok || len(l.Val) != 2 {
+			return nil, errors.New("msgpack-decode of hashmap failed: expected [data, meta] pair")
+		}
+		hm, ok := l.Val[0].(HashMap)
+		if !ok {
+			return nil, errors.New("msgpack-decode of hashmap failed: expected map data")
+		}
+		hm.Meta = l.Val[1]
+		return hm, nil
+	default:
+		return nil, fmt.Errorf("msgpack-decode: unsupported ext type %d", int8(codeByte))
+	}
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMsgPackDecoderRejectsExcessiveNesting(t *testing.T) {
+	const depth = DefaultMsgPackMaxDepth + 1
+	var buf bytes.Buffer
+	for i := 0; i < depth; i++ {
+		buf.WriteByte(0x91) // fixarray of length 1
+	}
+	buf.WriteByte(mpNil)
+
+	_, err := NewMsgPackDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	var depthErr *MsgPackMaxDepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("expected *MsgPackMaxDepthError, got %v", err)
+	}
+	if depthErr.MaxDepth != DefaultMsgPackMaxDepth {
+		t.Fatalf("MaxDepth = %d, want %d", depthErr.MaxDepth, DefaultMsgPackMaxDepth)
+	}
+}
+
+func TestMsgPackDecoderSetMaxDepth(t *testing.T) {
+	const depth = 50
+	var buf bytes.Buffer
+	for i := 0; i < depth; i++ {
+		buf.WriteByte(0x91)
+	}
+	buf.WriteByte(mpNil)
+
+	dec := NewMsgPackDecoder(bytes.NewReader(buf.Bytes()))
+	dec.SetMaxDepth(depth - 1)
+	if _, err := dec.Decode(); err == nil {
+		t.Fatalf("expected error with MaxDepth below actual nesting")
+	}
+
+	dec2 := NewMsgPackDecoder(bytes.NewReader(buf.Bytes()))
+	dec2.SetMaxDepth(depth)
+	if _, err := dec2.Decode(); err != nil {
+		t.Fatalf("unexpected error with sufficient MaxDepth: %v", err)
+	}
+}
+
+func TestMsgPackRoundTripsAtomAndVectorMeta(t *testing.T) {
+	v := Vector{Val: []MalType{1, 2, 3}, Meta: "somemeta"}
+	b, err := MarshalMsgPack(v)
+	if err != nil {
+		t.Fatalf("MarshalMsgPack(vector): %v", err)
+	}
+	got, err := UnmarshalMsgPack(b)
+	if err != nil {
+		t.Fatalf("UnmarshalMsgPack(vector): %v", err)
+	}
+	gv, ok := got.(Vector)
+	if !ok || gv.Meta != "somemeta" {
+		t.Fatalf("round-tripped Vector = %#v, want Meta %q", got, "somemeta")
+	}
+
+	a := &Atom{Val: json.Number("1"), Meta: "othermeta"}
+	b, err = MarshalMsgPack(a)
+	if err != nil {
+		t.Fatalf("MarshalMsgPack(atom): %v", err)
+	}
+	got, err = UnmarshalMsgPack(b)
+	if err != nil {
+		t.Fatalf("UnmarshalMsgPack(atom): %v", err)
+	}
+	ga, ok := got.(*Atom)
+	if !ok || ga.Meta != "othermeta" {
+		t.Fatalf("round-tripped Atom = %#v, want Meta %q", got, "othermeta")
+	}
+}
+
+func TestMsgPackRoundTripsListMeta(t *testing.T) {
+	l := List{Val: ListMalType{json.Number("1"), json.Number("2")}, Meta: "listmeta"}
+	b, err := MarshalMsgPack(l)
+	if err != nil {
+		t.Fatalf("MarshalMsgPack(list): %v", err)
+	}
+	got, err := UnmarshalMsgPack(b)
+	if err != nil {
+		t.Fatalf("UnmarshalMsgPack(list): %v", err)
+	}
+	gl, ok := got.(List)
+	if !ok || gl.Meta != "listmeta" {
+		t.Fatalf("round-tripped List = %#v, want Meta %q", got, "listmeta")
+	}
+	if !Equal_Q(gl, List{Val: l.Val}) {
+		t.Fatalf("round-tripped List elements = %#v, want %#v", gl.Val, l.Val)
+	}
+}
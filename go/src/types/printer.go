@@ -0,0 +1,105 @@
+package types
+
+// Pr_str renders a MalType as mal source text.
+//
+// This tree only carries the types package (the reader, eval and the
+// rest of the usual mal printer live in packages not present here), so
+// Pr_str covers just what types itself needs to print: scalars, the
+// collection types and atoms. It does not know about environments or
+// special forms.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pr_str renders obj as mal source text. Lists, vectors and hash-maps
+// recurse element-wise; when print_readably is true, strings are
+// escaped and quoted the way the reader expects to read them back.
+//
+// Because atoms are mutable references, obj can be self-referential
+// (e.g. after (reset! a a)) or cyclic through a chain of atoms and
+// lists. Pr_str tracks the identities it is currently rendering and
+// prints "#<cycle>" instead of recursing back into one of them, so it
+// always terminates.
+func Pr_str(obj MalType, print_readably bool) string {
+	return pr_str(obj, print_readably, map[uintptr]bool{})
+}
+
+func pr_str(obj MalType, print_readably bool, inProgress map[uintptr]bool) string {
+	if id, ok := identity(obj); ok {
+		if inProgress[id] {
+			return "#<cycle>"
+		}
+		inProgress[id] = true
+		defer delete(inProgress, id)
+	}
+
+	switch v := obj.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case int:
+		return strconv.Itoa(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if Keyword_Q(v) {
+			return ":" + strings.TrimPrefix(v, "\u029e")
+		}
+		if print_readably {
+			return `"` + escapeString(v) + `"`
+		}
+		return v
+	case Symbol:
+		return v.Val
+	case List:
+		return "(" + prStrSeq(v.Val, print_readably, inProgress) + ")"
+	case Vector:
+		return "[" + prStrSeq(v.Val, print_readably, inProgress) + "]"
+	case HashMap:
+		var parts []string
+		v.Val.Range(func(k string, val MalType) bool {
+			parts = append(parts, pr_str(k, print_readably, inProgress), pr_str(val, print_readably, inProgress))
+			return true
+		})
+		return "{" + strings.Join(parts, " ") + "}"
+	case *Atom:
+		return "(atom " + pr_str(v.Val, print_readably, inProgress) + ")"
+	case Func, MalFunc:
+		return "#<function>"
+	default:
+		return fmt.Sprintf("%v", obj)
+	}
+}
+
+func prStrSeq(items []MalType, print_readably bool, inProgress map[uintptr]bool) string {
+	parts := make([]string, len(items))
+	for i, it := range items {
+		parts[i] = pr_str(it, print_readably, inProgress)
+	}
+	return strings.Join(parts, " ")
+}
+
+func escapeString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
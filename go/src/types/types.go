@@ -1,8 +1,6 @@
 package types
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -130,94 +128,12 @@ func Apply(f_mt MalType, a []MalType) (MalType, error) {
 
 // Lists
 type List struct {
-	Val  ListMalType `json:"list"`
-	Meta MalType     `json:"meta,omitempty"`
+	Val  ListMalType
+	Meta MalType
 }
 
 type ListMalType []MalType
 
-// UnmarshalJSON custom unmarshaller for J
-func (j *ListMalType) UnmarshalJSON(b []byte) (err error) {
-	rawJSONAST := []json.RawMessage{}
-	err = JSONUnmarshal(b, &rawJSONAST)
-	if err != nil {
-		return err
-	}
-
-	for _, raw := range rawJSONAST {
-		switch raw[0] {
-		case '{':
-			m := map[string]interface{}{}
-			err = json.Unmarshal(raw, &m)
-			if err != nil {
-				return err
-			}
-			if _, ok := m["symbol"]; ok {
-				res := Symbol{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of symbol failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else if _, ok := m["atom"]; ok {
-				res := Atom{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of atom failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else if _, ok := m["list"]; ok {
-				res := List{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of list failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else if _, ok := m["hashmap"]; ok {
-				res := HashMap{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of hashmap failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else if _, ok := m["vector"]; ok {
-				res := Vector{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of vector failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else if _, ok := m["fn"]; ok { // won't work
-				res := Func{}
-				if e := json.Unmarshal(raw, &res); e != nil {
-					return fmt.Errorf("json-decode of fn failed: %v", e)
-				}
-				*j = append(*j, res)
-			} else {
-				return errors.New("json-decode of unknown type")
-			}
-		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-			item := json.Number("")
-			err = JSONUnmarshal(raw, &item)
-			if err != nil {
-				return err
-			}
-			*j = append(*j, item)
-		default:
-			var item interface{}
-			err = JSONUnmarshal(raw, &item)
-			if err != nil {
-				return err
-			}
-			*j = append(*j, item)
-		}
-	}
-	return nil
-}
-
-func JSONUnmarshal(buffer []byte, ast interface{}) error {
-	reader := bytes.NewReader(buffer)
-	decoder := json.NewDecoder(reader)
-	decoder.UseNumber()
-	decoder.DisallowUnknownFields()
-	return decoder.Decode(ast)
-}
-
 func NewList(a ...MalType) MalType {
 	return List{a, nil}
 }
@@ -229,8 +145,8 @@ func List_Q(obj MalType) bool {
 
 // Vectors
 type Vector struct {
-	Val  []MalType `json:"vector"`
-	Meta MalType   `json:"meta,omitempty"`
+	Val  []MalType
+	Meta MalType
 }
 
 func Vector_Q(obj MalType) bool {
@@ -251,8 +167,85 @@ func GetSlice(seq MalType) ([]MalType, error) {
 
 // Hash Maps
 type HashMap struct {
-	Val  map[string]MalType `json:"hashmap"`
-	Meta MalType            `json:"meta,omitempty"`
+	Val  *MalHashMap
+	Meta MalType
+}
+
+// MalHashMap is a string-keyed map that remembers the order keys were
+// first inserted in, so that keys, vals and pr-str can walk entries in
+// that order instead of Go's randomized map iteration order.
+type MalHashMap struct {
+	keys []string
+	vals map[string]MalType
+}
+
+func NewMalHashMap() *MalHashMap {
+	return &MalHashMap{vals: map[string]MalType{}}
+}
+
+// Set inserts or updates key. Updating an existing key does not change
+// its position in iteration order.
+func (m *MalHashMap) Set(key string, val MalType) {
+	if _, ok := m.vals[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.vals[key] = val
+}
+
+// Delete removes key, if present, closing the gap it left in order.
+func (m *MalHashMap) Delete(key string) {
+	if _, ok := m.vals[key]; !ok {
+		return
+	}
+	delete(m.vals, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Get, Len, Keys and Range all treat a nil *MalHashMap as empty, the
+// same way a nil Go map reads as empty, so a zero-value HashMap{} (as
+// produced by e.g. a decoder error path or a bare struct literal)
+// compares, prints and marshals without a nil-pointer dereference.
+
+func (m *MalHashMap) Get(key string) (MalType, bool) {
+	if m == nil {
+		return nil, false
+	}
+	v, ok := m.vals[key]
+	return v, ok
+}
+
+func (m *MalHashMap) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.keys)
+}
+
+// Keys returns the map's keys in insertion order. Callers must not
+// mutate the returned slice.
+func (m *MalHashMap) Keys() []string {
+	if m == nil {
+		return nil
+	}
+	return m.keys
+}
+
+// Range calls fn for each entry in insertion order, stopping early if
+// fn returns false.
+func (m *MalHashMap) Range(fn func(key string, val MalType) bool) {
+	if m == nil {
+		return
+	}
+	for _, k := range m.keys {
+		if !fn(k, m.vals[k]) {
+			return
+		}
+	}
 }
 
 func NewHashMap(seq MalType) (MalType, error) {
@@ -263,13 +256,13 @@ func NewHashMap(seq MalType) (MalType, error) {
 	if len(lst)%2 == 1 {
 		return nil, errors.New("Odd number of arguments to NewHashMap")
 	}
-	m := map[string]MalType{}
+	m := NewMalHashMap()
 	for i := 0; i < len(lst); i += 2 {
 		str, ok := lst[i].(string)
 		if !ok {
 			return nil, errors.New("expected hash-map key string")
 		}
-		m[str] = lst[i+1]
+		m.Set(str, lst[i+1])
 	}
 	return HashMap{m, nil}, nil
 }
@@ -281,8 +274,8 @@ func HashMap_Q(obj MalType) bool {
 
 // Atoms
 type Atom struct {
-	Val  MalType `json:"atom"`
-	Meta MalType `json:"meta,omitempty"`
+	Val  MalType
+	Meta MalType
 }
 
 func (a *Atom) Set(val MalType) MalType {
@@ -312,15 +305,63 @@ func Sequential_Q(seq MalType) bool {
 		(reflect.TypeOf(seq).Name() == "Vector")
 }
 
+// identity returns a stable pointer identity for obj's underlying
+// storage, for the reference-like types that a mutated atom can wire
+// into a cycle: a List/Vector's backing array, a HashMap's *MalHashMap,
+// or an Atom itself. ok is false for values with no identity worth
+// tracking (scalars, and empty sequences, which can't loop back on
+// themselves).
+func identity(obj MalType) (id uintptr, ok bool) {
+	switch v := obj.(type) {
+	case List:
+		if len(v.Val) == 0 {
+			return 0, false
+		}
+		return reflect.ValueOf(v.Val).Pointer(), true
+	case Vector:
+		if len(v.Val) == 0 {
+			return 0, false
+		}
+		return reflect.ValueOf(v.Val).Pointer(), true
+	case HashMap:
+		if v.Val == nil {
+			return 0, false
+		}
+		return reflect.ValueOf(v.Val).Pointer(), true
+	case *Atom:
+		return reflect.ValueOf(v).Pointer(), true
+	default:
+		return 0, false
+	}
+}
+
+// cyclePair identifies a pair of identities currently being compared,
+// so equal_q can recognize it has looped back to a comparison already
+// in progress (e.g. via (reset! a a), or an atom holding a list that
+// holds the atom) and treat it as equal rather than recurse forever.
+type cyclePair struct {
+	a, b uintptr
+}
+
 func Equal_Q(a MalType, b MalType) bool {
+	return equal_q(a, b, map[cyclePair]bool{})
+}
+
+func equal_q(a MalType, b MalType, visited map[cyclePair]bool) bool {
 	ota := reflect.TypeOf(a)
 	otb := reflect.TypeOf(b)
 	if !((ota == otb) || (Sequential_Q(a) && Sequential_Q(b))) {
 		return false
 	}
-	//av := reflect.ValueOf(a); bv := reflect.ValueOf(b)
-	//fmt.Printf("here2: %#v\n", reflect.TypeOf(a).Name())
-	//switch reflect.TypeOf(a).Name() {
+	if ida, oka := identity(a); oka {
+		if idb, okb := identity(b); okb {
+			key := cyclePair{ida, idb}
+			if visited[key] {
+				return true
+			}
+			visited[key] = true
+		}
+	}
 	switch a.(type) {
 	case Symbol:
 		return a.(Symbol).Val == b.(Symbol).Val
@@ -331,7 +372,7 @@ func Equal_Q(a MalType, b MalType) bool {
 			return false
 		}
 		for i := 0; i < len(as); i += 1 {
-			if !Equal_Q(as[i], bs[i]) {
+			if !equal_q(as[i], bs[i], visited) {
 				return false
 			}
 		}
@@ -343,7 +384,7 @@ func Equal_Q(a MalType, b MalType) bool {
 			return false
 		}
 		for i := 0; i < len(as); i += 1 {
-			if !Equal_Q(as[i], bs[i]) {
+			if !equal_q(as[i], bs[i], visited) {
 				return false
 			}
 		}
@@ -351,15 +392,25 @@ func Equal_Q(a MalType, b MalType) bool {
 	case HashMap:
 		am := a.(HashMap).Val
 		bm := b.(HashMap).Val
-		if len(am) != len(bm) {
+		if am.Len() != bm.Len() {
 			return false
 		}
-		for k, v := range am {
-			if !Equal_Q(v, bm[k]) {
+		equal := true
+		am.Range(func(k string, v MalType) bool {
+			bv, ok := bm.Get(k)
+			if !ok || !equal_q(v, bv, visited) {
+				equal = false
 				return false
 			}
-		}
-		return true
+			return true
+		})
+		return equal
+	case *Atom:
+		// Atoms are mutable references, so compare by contents rather
+		// than identity; the visited-pair check above makes this safe
+		// for atoms that (directly or through a chain of lists/atoms)
+		// end up referencing themselves.
+		return equal_q(a.(*Atom).Val, b.(*Atom).Val, visited)
 	default:
 		return a == b
 	}